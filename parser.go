@@ -0,0 +1,531 @@
+package cascadia
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// a parser holds the state needed to turn a selector string into a Selector.
+type parser struct {
+	s string // the selector being parsed
+	i int    // the current position in s
+}
+
+// nameStart returns whether c can be the first character of an identifier.
+func nameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// nameChar returns whether c can appear in an identifier after the first character.
+func nameChar(c byte) bool {
+	return c == '-' || nameStart(c) || '0' <= c && c <= '9'
+}
+
+// skipWhitespace consumes any whitespace at the current position, and
+// reports whether it consumed anything.
+func (p *parser) skipWhitespace() bool {
+	i := p.i
+	for i < len(p.s) {
+		switch p.s[i] {
+		case ' ', '\t', '\r', '\n', '\f':
+			i++
+		default:
+			goto done
+		}
+	}
+done:
+	if i > p.i {
+		p.i = i
+		return true
+	}
+	return false
+}
+
+// parseIdentifier parses a CSS identifier (tag name, class name, id, etc).
+func (p *parser) parseIdentifier() (string, error) {
+	start := p.i
+
+	if p.i < len(p.s) && p.s[p.i] == '-' {
+		p.i++
+	}
+
+	if p.i >= len(p.s) || !nameStart(p.s[p.i]) {
+		return "", fmt.Errorf("expected identifier, found %q", p.s[start:])
+	}
+	p.i++
+
+	for p.i < len(p.s) && nameChar(p.s[p.i]) {
+		p.i++
+	}
+
+	return p.s[start:p.i], nil
+}
+
+// parseString parses a single- or double-quoted string.
+func (p *parser) parseString() (string, error) {
+	if p.i >= len(p.s) {
+		return "", fmt.Errorf("expected string, found EOF instead")
+	}
+
+	quote := p.s[p.i]
+	p.i++
+	start := p.i
+	for p.i < len(p.s) && p.s[p.i] != quote {
+		p.i++
+	}
+	if p.i >= len(p.s) {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	result := p.s[start:p.i]
+	p.i++ // skip closing quote
+	return result, nil
+}
+
+// parseTypeSelector parses a type selector (a tag name) or the universal
+// selector ("*").
+func (p *parser) parseTypeSelector() (Selector, error) {
+	if p.i < len(p.s) && p.s[p.i] == '*' {
+		p.i++
+		return universalSelector(), nil
+	}
+
+	tag, err := p.parseIdentifier()
+	if err != nil {
+		return Selector{}, err
+	}
+
+	return typeSelector(tag), nil
+}
+
+// parseIDSelector parses an ID selector ("#foo").
+func (p *parser) parseIDSelector() (Selector, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '#' {
+		return Selector{}, fmt.Errorf("expected id selector (#id), found %q", p.s[p.i:])
+	}
+	p.i++
+
+	id, err := p.parseIdentifier()
+	if err != nil {
+		return Selector{}, err
+	}
+
+	return idSelector(id), nil
+}
+
+// parseClassSelector parses a class selector (".foo").
+func (p *parser) parseClassSelector() (Selector, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '.' {
+		return Selector{}, fmt.Errorf("expected class selector (.class), found %q", p.s[p.i:])
+	}
+	p.i++
+
+	class, err := p.parseIdentifier()
+	if err != nil {
+		return Selector{}, err
+	}
+
+	return classSelector(class), nil
+}
+
+// parseAttributeSelector parses an attribute selector, such as "[href]",
+// `[href="foo"]`, or `[href^="foo"]`.
+func (p *parser) parseAttributeSelector() (Selector, error) {
+	if p.i >= len(p.s) || p.s[p.i] != '[' {
+		return Selector{}, fmt.Errorf("expected attribute selector ([attr]), found %q", p.s[p.i:])
+	}
+	p.i++
+	p.skipWhitespace()
+
+	key, err := p.parseIdentifier()
+	if err != nil {
+		return Selector{}, err
+	}
+	p.skipWhitespace()
+
+	if p.i >= len(p.s) {
+		return Selector{}, fmt.Errorf("unexpected EOF in attribute selector")
+	}
+
+	if p.s[p.i] == ']' {
+		p.i++
+		return attributeExistsSelector(key), nil
+	}
+
+	op := p.s[p.i]
+	if op != '=' {
+		p.i++
+		if p.i >= len(p.s) || p.s[p.i] != '=' {
+			return Selector{}, fmt.Errorf("expected '=' in attribute selector")
+		}
+	}
+	p.i++
+	p.skipWhitespace()
+
+	if p.i >= len(p.s) {
+		return Selector{}, fmt.Errorf("unexpected EOF in attribute selector")
+	}
+
+	var val string
+	if p.s[p.i] == '\'' || p.s[p.i] == '"' {
+		val, err = p.parseString()
+	} else {
+		val, err = p.parseIdentifier()
+	}
+	if err != nil {
+		return Selector{}, err
+	}
+	p.skipWhitespace()
+
+	if p.i >= len(p.s) || p.s[p.i] != ']' {
+		return Selector{}, fmt.Errorf("expected ']' to close attribute selector")
+	}
+	p.i++
+
+	switch op {
+	case '=':
+		return attributeEqualsSelector(key, val), nil
+	case '~':
+		return attributeIncludesSelector(key, val), nil
+	case '|':
+		return attributeDashmatchSelector(key, val), nil
+	case '^':
+		return attributePrefixSelector(key, val), nil
+	case '$':
+		return attributeSuffixSelector(key, val), nil
+	case '*':
+		return attributeSubstringSelector(key, val), nil
+	}
+
+	return Selector{}, fmt.Errorf("attribute operator %q is not supported", string(op))
+}
+
+// nthRegexp matches the argument to :nth-child() and :nth-last-child(),
+// e.g. "2n+1", "-n+3", "5".
+var nthRegexp = regexp.MustCompile(`^\s*([+-]?\d*n)?\s*([+-]?\s*\d+)?\s*$`)
+
+// parseNth parses the an+b expression used by :nth-child() and friends.
+func parseNth(s string) (a, b int, err error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+
+	m := nthRegexp.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, 0, fmt.Errorf("invalid an+b expression %q", s)
+	}
+
+	switch an := strings.TrimSuffix(m[1], "n"); an {
+	case "":
+		if m[1] == "" {
+			a = 0
+		} else {
+			a = 1
+		}
+	case "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		a, err = strconv.Atoi(an)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if bs := strings.Replace(m[2], " ", "", -1); bs != "" {
+		b, err = strconv.Atoi(bs)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return a, b, nil
+}
+
+// parsePseudoclassSelector parses a pseudo-class selector, such as
+// ":not(.foo)" or ":nth-child(2n+1)".
+func (p *parser) parsePseudoclassSelector() (Selector, error) {
+	if p.i >= len(p.s) || p.s[p.i] != ':' {
+		return Selector{}, fmt.Errorf("expected pseudoclass selector (:pseudoclass), found %q", p.s[p.i:])
+	}
+	p.i++
+
+	name, err := p.parseIdentifier()
+	if err != nil {
+		return Selector{}, err
+	}
+	name = toLowerASCII(name)
+
+	switch name {
+	case "root":
+		return rootSelector(), nil
+
+	case "empty":
+		return emptySelector(), nil
+
+	case "first-child":
+		return firstChildSelector(), nil
+
+	case "last-child":
+		return lastChildSelector(), nil
+
+	case "only-child":
+		return onlyChildSelector(), nil
+
+	case "first-of-type":
+		return firstOfTypeSelector(), nil
+
+	case "last-of-type":
+		return lastOfTypeSelector(), nil
+
+	case "only-of-type":
+		return onlyOfTypeSelector(), nil
+
+	case "not":
+		if p.i >= len(p.s) || p.s[p.i] != '(' {
+			return Selector{}, fmt.Errorf("expected '(' after :not")
+		}
+		p.i++
+		p.skipWhitespace()
+
+		sel, err := p.parseSimpleSelectorSequence()
+		if err != nil {
+			return Selector{}, err
+		}
+		p.skipWhitespace()
+
+		if p.i >= len(p.s) || p.s[p.i] != ')' {
+			return Selector{}, fmt.Errorf("expected ')' to close :not()")
+		}
+		p.i++
+
+		return negatedSelector(sel), nil
+
+	case "has":
+		if p.i >= len(p.s) || p.s[p.i] != '(' {
+			return Selector{}, fmt.Errorf("expected '(' after :has")
+		}
+		p.i++
+		p.skipWhitespace()
+
+		sel, err := p.parseSelectorGroup()
+		if err != nil {
+			return Selector{}, err
+		}
+		p.skipWhitespace()
+
+		if p.i >= len(p.s) || p.s[p.i] != ')' {
+			return Selector{}, fmt.Errorf("expected ')' to close :has()")
+		}
+		p.i++
+
+		return hasSelector(sel), nil
+
+	case "contains":
+		if p.i >= len(p.s) || p.s[p.i] != '(' {
+			return Selector{}, fmt.Errorf("expected '(' after :contains")
+		}
+		p.i++
+		p.skipWhitespace()
+
+		if p.i >= len(p.s) || (p.s[p.i] != '\'' && p.s[p.i] != '"') {
+			return Selector{}, fmt.Errorf("expected quoted string in :contains()")
+		}
+		value, err := p.parseString()
+		if err != nil {
+			return Selector{}, err
+		}
+		p.skipWhitespace()
+
+		if p.i >= len(p.s) || p.s[p.i] != ')' {
+			return Selector{}, fmt.Errorf("expected ')' to close :contains()")
+		}
+		p.i++
+
+		return containsSelector(value), nil
+
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		a, b, err := p.parseNthArgument(name)
+		if err != nil {
+			return Selector{}, err
+		}
+
+		switch name {
+		case "nth-child":
+			return nthChildSelector(a, b, false), nil
+		case "nth-last-child":
+			return nthChildSelector(a, b, true), nil
+		case "nth-of-type":
+			return nthOfTypeSelector(a, b, false), nil
+		default: // "nth-last-of-type"
+			return nthOfTypeSelector(a, b, true), nil
+		}
+	}
+
+	return Selector{}, fmt.Errorf("unknown pseudoclass :%s", name)
+}
+
+// parseNthArgument parses the parenthesized an+b expression used by
+// :nth-child(), :nth-last-child(), :nth-of-type() and :nth-last-of-type().
+func (p *parser) parseNthArgument(name string) (a, b int, err error) {
+	if p.i >= len(p.s) || p.s[p.i] != '(' {
+		return 0, 0, fmt.Errorf("expected '(' after :%s", name)
+	}
+	p.i++
+	start := p.i
+	for p.i < len(p.s) && p.s[p.i] != ')' {
+		p.i++
+	}
+	if p.i >= len(p.s) {
+		return 0, 0, fmt.Errorf("unexpected EOF in :%s()", name)
+	}
+
+	a, b, err = parseNth(p.s[start:p.i])
+	if err != nil {
+		return 0, 0, err
+	}
+	p.i++ // skip ')'
+
+	return a, b, nil
+}
+
+// parseSimpleSelectorSequence parses a type selector along with any number
+// of id, class, attribute and pseudo-class selectors that follow it, e.g.
+// "div#foo.bar[baz]:not(.qux)".
+func (p *parser) parseSimpleSelectorSequence() (Selector, error) {
+	var result Selector
+
+	if p.i >= len(p.s) {
+		return Selector{}, fmt.Errorf("expected selector, found EOF instead")
+	}
+
+	switch p.s[p.i] {
+	case '*':
+		p.i++
+	case '#', '.', '[', ':':
+		// there's no type selector; the sequence starts right away with one
+		// of the modifiers below.
+	default:
+		r, err := p.parseTypeSelector()
+		if err != nil {
+			return Selector{}, err
+		}
+		result = r
+	}
+
+loop:
+	for p.i < len(p.s) {
+		var (
+			ns  Selector
+			err error
+		)
+
+		switch p.s[p.i] {
+		case '#':
+			ns, err = p.parseIDSelector()
+		case '.':
+			ns, err = p.parseClassSelector()
+		case '[':
+			ns, err = p.parseAttributeSelector()
+		case ':':
+			ns, err = p.parsePseudoclassSelector()
+		default:
+			break loop
+		}
+		if err != nil {
+			return Selector{}, err
+		}
+
+		if result.ast == nil {
+			result = ns
+		} else {
+			result = intersectionSelector(result, ns)
+		}
+	}
+
+	if result.ast == nil {
+		result = universalSelector()
+	}
+
+	return result, nil
+}
+
+// parseSelector parses a selector that may include combinators, e.g.
+// "div.foo > a[href^=\"http\"] + span".
+func (p *parser) parseSelector() (Selector, error) {
+	p.skipWhitespace()
+
+	result, err := p.parseSimpleSelectorSequence()
+	if err != nil {
+		return Selector{}, err
+	}
+
+loop:
+	for {
+		var combinator byte
+		if p.skipWhitespace() {
+			combinator = ' '
+		}
+
+		if p.i >= len(p.s) {
+			break loop
+		}
+
+		switch p.s[p.i] {
+		case '>', '+', '~':
+			combinator = p.s[p.i]
+			p.i++
+			p.skipWhitespace()
+		case ',', ')':
+			break loop
+		}
+
+		if combinator == 0 {
+			break loop
+		}
+
+		c, err := p.parseSimpleSelectorSequence()
+		if err != nil {
+			return Selector{}, err
+		}
+
+		switch combinator {
+		case ' ':
+			result = descendantSelector(result, c)
+		case '>':
+			result = childSelector(result, c)
+		case '+':
+			result = siblingSelector(result, c, true)
+		case '~':
+			result = siblingSelector(result, c, false)
+		}
+	}
+
+	return result, nil
+}
+
+// parseSelectorGroup parses a comma-separated group of selectors, e.g.
+// "a, b, c".
+func (p *parser) parseSelectorGroup() (Selector, error) {
+	result, err := p.parseSelector()
+	if err != nil {
+		return Selector{}, err
+	}
+
+	for p.i < len(p.s) && p.s[p.i] == ',' {
+		p.i++
+		c, err := p.parseSelector()
+		if err != nil {
+			return Selector{}, err
+		}
+		result = unionSelector(result, c)
+	}
+
+	return result, nil
+}
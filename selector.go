@@ -2,50 +2,279 @@ package cascadia
 
 import (
 	"fmt"
-	"html"
 	"strings"
+
+	"golang.org/x/net/html"
 )
 
 // the Selector type, and functions for creating them
 
-// A Selector is a function which tells whether a node matches or not.
-type Selector func(*html.Node) bool
+// A Selector matches html.Node objects, and can also serialize itself back
+// to a canonical CSS string. It is returned by Compile.
+type Selector struct {
+	matches func(*html.Node) bool
+	ast     selNode // the parsed structure, used by String
+}
+
+// selNode is the AST for a compiled Selector. Each selector constructor
+// below pairs a matching closure with a selNode that knows how to render
+// that piece of the selector back to CSS; combinators combine both the
+// closures and the ASTs of their operands.
+type selNode interface {
+	String() string
+	specificity() [3]int
+}
+
+// A Matcher is the interface for basic selector functionality. Selector
+// satisfies this interface, meaning that any function that accepts a
+// Matcher as an argument can also accept a Selector without the caller
+// having to wrap it, and without cascadia's compiled selectors leaking
+// into the caller's API.
+type Matcher interface {
+	// Match returns whether a node matches.
+	Match(n *html.Node) bool
+	// MatchAll returns all nodes that match, from n and its descendants.
+	MatchAll(n *html.Node) []*html.Node
+	// Filter returns the nodes in nodes that match.
+	Filter(nodes []*html.Node) []*html.Node
+}
+
+var _ Matcher = Selector{}
+
+// Match returns whether n matches the selector, satisfying the Matcher
+// interface.
+func (s Selector) Match(n *html.Node) bool {
+	return s.matches(n)
+}
+
+// Filter returns the nodes in nodes that match the selector.
+func (s Selector) Filter(nodes []*html.Node) (result []*html.Node) {
+	for _, n := range nodes {
+		if s.matches(n) {
+			result = append(result, n)
+		}
+	}
+	return
+}
+
+// String returns the canonical CSS string for the selector, as parsed by
+// Compile. It is useful for debugging, logging, and as a cache key.
+func (s Selector) String() string {
+	return s.ast.String()
+}
+
+// Specificity returns the CSS specificity of the selector, as the triple
+// (ID count, class/attribute/pseudo-class count, type/pseudo-element
+// count). It is used to order matches when several selectors match the
+// same node, as in CSS cascade resolution. For a comma-separated group,
+// it returns the sum of the specificities of its alternatives, since the
+// group as a whole does not have a single well-defined specificity.
+func (s Selector) Specificity() [3]int {
+	return s.ast.specificity()
+}
 
 // Compile parses a selector and returns, if successful, a Selector object
-// that can be used to match against html.Node objects.
+// that can be used to match against html.Node objects. The full selector
+// grammar is supported: descendant ("a b"), child ("a > b"), adjacent
+// sibling ("a + b"), general sibling ("a ~ b") combinators, and
+// comma-separated selector groups ("a, b, c").
 func Compile(sel string) (Selector, error) {
 	p := &parser{s: sel}
-	compiled, err := p.parseSimpleSelectorSequence() // TODO: more complicated selectors
+	compiled, err := p.parseSelectorGroup()
 	if err != nil {
-		return nil, err
+		return Selector{}, err
 	}
 
 	if p.i < len(sel) {
-		return nil, fmt.Errorf("parsing %q: %d bytes left over", sel, len(sel)-p.i)
+		return Selector{}, fmt.Errorf("parsing %q: %d bytes left over", sel, len(sel)-p.i)
 	}
 
 	return compiled, nil
 }
 
 // MatchAll returns a slice of the nodes that match the selector,
-// from n and its children.
+// from n and its descendants, in document order. It walks the DOM with an
+// explicit stack instead of recursing, so that deeply nested documents
+// don't exhaust the goroutine stack.
 func (s Selector) MatchAll(n *html.Node) (result []*html.Node) {
-	if s(n) {
-		result = append(result, n)
-	}
+	for stack := []*html.Node{n}; len(stack) > 0; {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if s.matches(n) {
+			result = append(result, n)
+		}
 
-	for _, child := range n.Child {
-		result = append(result, s.MatchAll(child)...)
+		for child := n.LastChild; child != nil; child = child.PrevSibling {
+			stack = append(stack, child)
+		}
 	}
 
 	return
 }
 
+// MatchFirst returns the first node matching the selector, in document
+// order, within n and its descendants. It stops walking the DOM as soon as
+// a match is found, so callers that only need the first hit don't pay for
+// the rest of the tree.
+func (s Selector) MatchFirst(n *html.Node) *html.Node {
+	for stack := []*html.Node{n}; len(stack) > 0; {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if s.matches(n) {
+			return n
+		}
+
+		for child := n.LastChild; child != nil; child = child.PrevSibling {
+			stack = append(stack, child)
+		}
+	}
+
+	return nil
+}
+
+// combinatorNode is the AST node for two selectors joined by a combinator:
+// descendant (' '), child ('>'), adjacent sibling ('+'), general sibling
+// ('~'), or a comma-separated group (',').
+type combinatorNode struct {
+	a, b       selNode
+	combinator byte
+}
+
+func (n combinatorNode) String() string {
+	if n.combinator == ',' {
+		return n.a.String() + ", " + n.b.String()
+	}
+	if n.combinator == ' ' {
+		return n.a.String() + " " + n.b.String()
+	}
+	return n.a.String() + " " + string(n.combinator) + " " + n.b.String()
+}
+
+func (n combinatorNode) specificity() [3]int {
+	as, bs := n.a.specificity(), n.b.specificity()
+	return [3]int{as[0] + bs[0], as[1] + bs[1], as[2] + bs[2]}
+}
+
+// descendantSelector returns a selector that matches nodes matching b
+// that have an ancestor matching a.
+func descendantSelector(a, b Selector) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if !b.matches(n) {
+				return false
+			}
+
+			for p := n.Parent; p != nil; p = p.Parent {
+				if a.matches(p) {
+					return true
+				}
+			}
+
+			return false
+		},
+		ast: combinatorNode{a.ast, b.ast, ' '},
+	}
+}
+
+// childSelector returns a selector that matches nodes matching b whose
+// parent matches a.
+func childSelector(a, b Selector) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return b.matches(n) && n.Parent != nil && a.matches(n.Parent)
+		},
+		ast: combinatorNode{a.ast, b.ast, '>'},
+	}
+}
+
+// siblingSelector returns a selector that matches nodes matching b that
+// have a preceding sibling matching a. If adjacent is true, only the
+// immediately preceding element sibling is considered (the "+" combinator);
+// otherwise any preceding sibling is considered (the "~" combinator).
+func siblingSelector(a, b Selector, adjacent bool) Selector {
+	combinator := byte('~')
+	if adjacent {
+		combinator = '+'
+	}
+
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if !b.matches(n) {
+				return false
+			}
+
+			if adjacent {
+				for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+					if s.Type == html.TextNode || s.Type == html.CommentNode {
+						continue
+					}
+					return a.matches(s)
+				}
+				return false
+			}
+
+			for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+				if a.matches(s) {
+					return true
+				}
+			}
+
+			return false
+		},
+		ast: combinatorNode{a.ast, b.ast, combinator},
+	}
+}
+
+// unionSelector returns a selector that matches nodes that match either a
+// or b, implementing comma-separated selector groups.
+func unionSelector(a, b Selector) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return a.matches(n) || b.matches(n)
+		},
+		ast: combinatorNode{a.ast, b.ast, ','},
+	}
+}
+
+// typeNode is the AST node for a type selector ("div") or the universal
+// selector ("*").
+type typeNode struct {
+	tag string // "" for the universal selector
+}
+
+func (n typeNode) String() string {
+	if n.tag == "" {
+		return "*"
+	}
+	return n.tag
+}
+
+func (n typeNode) specificity() [3]int {
+	if n.tag == "" {
+		return [3]int{}
+	}
+	return [3]int{0, 0, 1}
+}
+
 // typeSelector returns a Selector that matches nodes with a given tag name.
 func typeSelector(tag string) Selector {
 	tag = toLowerASCII(tag)
-	return func(n *html.Node) bool {
-		return n.Type == html.ElementNode && n.Data == tag
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return n.Type == html.ElementNode && n.Data == tag
+		},
+		ast: typeNode{tag},
+	}
+}
+
+// universalSelector returns a Selector that matches any node at all,
+// serializing as "*".
+func universalSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool { return true },
+		ast:     typeNode{},
 	}
 }
 
@@ -69,17 +298,92 @@ func toLowerASCII(s string) string {
 	return string(b)
 }
 
+// idNode is the AST node for an ID selector ("#foo").
+type idNode struct{ id string }
+
+func (n idNode) String() string { return "#" + n.id }
+
+func (n idNode) specificity() [3]int { return [3]int{1, 0, 0} }
+
+// classNode is the AST node for a class selector (".foo").
+type classNode struct{ class string }
+
+func (n classNode) String() string { return "." + n.class }
+
+func (n classNode) specificity() [3]int { return [3]int{0, 1, 0} }
+
+// attrNode is the AST node for an attribute selector. op is "" for a bare
+// existence check ("[foo]"), and one of "=", "~=", "|=", "^=", "$=", "*="
+// otherwise.
+type attrNode struct {
+	key, val, op string
+}
+
+func (n attrNode) String() string {
+	if n.op == "" {
+		return fmt.Sprintf("[%s]", n.key)
+	}
+	return fmt.Sprintf("[%s%s%q]", n.key, n.op, n.val)
+}
+
+func (n attrNode) specificity() [3]int { return [3]int{0, 1, 0} }
+
+// idSelector returns a Selector that matches nodes whose "id" attribute
+// equals id.
+func idSelector(id string) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == "id" {
+					return a.Val == id
+				}
+			}
+			return false
+		},
+		ast: idNode{id: id},
+	}
+}
+
+// classSelector returns a Selector that matches nodes whose "class"
+// attribute is a whitespace-separated list that includes class.
+func classSelector(class string) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == "class" {
+					s := a.Val
+					for s != "" {
+						i := strings.IndexAny(s, " \t\r\n\f")
+						if i == -1 {
+							return s == class
+						}
+						if s[:i] == class {
+							return true
+						}
+						s = s[i+1:]
+					}
+				}
+			}
+			return false
+		},
+		ast: classNode{class: class},
+	}
+}
+
 // attributeExistsSelector returns a Selector that matches nodes that have
 // an attribute named key.
 func attributeExistsSelector(key string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				return true
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					return true
+				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key},
 	}
 }
 
@@ -87,37 +391,43 @@ func attributeExistsSelector(key string) Selector {
 // the attribute named key has the value val.
 func attributeEqualsSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				return a.Val == val
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					return a.Val == val
+				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "="},
 	}
 }
 
-// attributeIncludesSelector returns a Selector that matches nodes where 
+// attributeIncludesSelector returns a Selector that matches nodes where
 // the attribute named key is a whitespace-separated list that includes val.
 func attributeIncludesSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				s := a.Val
-				for s != "" {
-					i := strings.IndexAny(s, " \t\r\n\f")
-					if i == -1 {
-						return s == val
-					}
-					if s[:i] == val {
-						return true
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					s := a.Val
+					for s != "" {
+						i := strings.IndexAny(s, " \t\r\n\f")
+						if i == -1 {
+							return s == val
+						}
+						if s[:i] == val {
+							return true
+						}
+						s = s[i+1:]
 					}
-					s = s[i+1:]
 				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "~="},
 	}
 }
 
@@ -125,22 +435,25 @@ func attributeIncludesSelector(key, val string) Selector {
 // the attribute named key equals val or starts with val plus a hyphen.
 func attributeDashmatchSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				if a.Val == val {
-					return true
-				}
-				if len(a.Val) <= len(val) {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					if a.Val == val {
+						return true
+					}
+					if len(a.Val) <= len(val) {
+						return false
+					}
+					if a.Val[:len(val)] == val && a.Val[len(val)] == '-' {
+						return true
+					}
 					return false
 				}
-				if a.Val[:len(val)] == val && a.Val[len(val)] == '-' {
-					return true
-				}
-				return false
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "|="},
 	}
 }
 
@@ -148,13 +461,16 @@ func attributeDashmatchSelector(key, val string) Selector {
 // the attribute named key starts with val.
 func attributePrefixSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				return strings.HasPrefix(a.Val, val)
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					return strings.HasPrefix(a.Val, val)
+				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "^="},
 	}
 }
 
@@ -162,13 +478,16 @@ func attributePrefixSelector(key, val string) Selector {
 // the attribute named key ends with val.
 func attributeSuffixSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				return strings.HasSuffix(a.Val, val)
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					return strings.HasSuffix(a.Val, val)
+				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "$="},
 	}
 }
 
@@ -176,63 +495,404 @@ func attributeSuffixSelector(key, val string) Selector {
 // the attribute named key contains val.
 func attributeSubstringSelector(key, val string) Selector {
 	key = toLowerASCII(key)
-	return func(n *html.Node) bool {
-		for _, a := range n.Attr {
-			if a.Key == key {
-				return strings.Contains(a.Val, val)
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for _, a := range n.Attr {
+				if a.Key == key {
+					return strings.Contains(a.Val, val)
+				}
 			}
-		}
-		return false
+			return false
+		},
+		ast: attrNode{key: key, val: val, op: "*="},
 	}
 }
 
 // intersectionSelector returns a selector that matches nodes that match
-// both a and b.
+// both a and b, i.e. the two halves of a compound selector like "p.foo".
 func intersectionSelector(a, b Selector) Selector {
-	return func(n *html.Node) bool {
-		return a(n) && b(n)
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return a.matches(n) && b.matches(n)
+		},
+		ast: intersectionNode{a.ast, b.ast},
 	}
 }
 
+// intersectionNode is the AST node for a compound selector, serialized by
+// concatenating its parts with no separator (e.g. "p" + ".foo" = "p.foo").
+type intersectionNode struct{ a, b selNode }
+
+func (n intersectionNode) String() string { return n.a.String() + n.b.String() }
+
+func (n intersectionNode) specificity() [3]int {
+	as, bs := n.a.specificity(), n.b.specificity()
+	return [3]int{as[0] + bs[0], as[1] + bs[1], as[2] + bs[2]}
+}
+
 // negatedSelector returns a selector that matches nodes that do not match a.
 func negatedSelector(a Selector) Selector {
-	return func(n *html.Node) bool {
-		return !a(n)
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return !a.matches(n)
+		},
+		ast: notNode{a.ast},
 	}
 }
 
+// notNode is the AST node for ":not(...)".
+type notNode struct{ sel selNode }
+
+func (n notNode) String() string { return ":not(" + n.sel.String() + ")" }
+
+// specificity counts the specificity of the argument selector, per the CSS
+// spec: the :not() pseudo-class itself is not counted, but what it
+// contains is.
+func (n notNode) specificity() [3]int { return n.sel.specificity() }
+
 // nthChildSelector returns a selector that implements :nth-child(an+b).
 // If last is true, implements :nth-last-child instead.
 func nthChildSelector(a, b int, last bool) Selector {
-	return func(n *html.Node) bool {
-		parent := n.Parent
-		if parent == nil {
-			return false
-		}
+	return Selector{
+		matches: func(n *html.Node) bool {
+			parent := n.Parent
+			if parent == nil {
+				return false
+			}
 
-		var i int
-		c := parent.Child
-		for i = 0; i < len(c); i++ {
-			if c[i] == n {
-				break
+			i := -1
+			count := 0
+			for c := parent.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode {
+					continue
+				}
+				count++
+				if c == n {
+					i = count - 1
+				}
 			}
-		}
 
-		if i == len(c) {
-			return false
-		}
+			if i == -1 {
+				return false
+			}
 
-		if last {
-			i = len(c) - i
-		} else {
-			i++
-		}
+			if last {
+				i = count - i
+			} else {
+				i++
+			}
 
-		i -= b
-		if a == 0 {
-			return i == 0
-		}
+			i -= b
+			if a == 0 {
+				return i == 0
+			}
+
+			return i%a == 0 && i/a >= 0
+		},
+		ast: nthChildNode{a: a, b: b, last: last},
+	}
+}
+
+// nthChildNode is the AST node for ":nth-child(an+b)" and
+// ":nth-last-child(an+b)".
+type nthChildNode struct {
+	a, b int
+	last bool
+}
+
+func (n nthChildNode) String() string {
+	name := "nth-child"
+	if n.last {
+		name = "nth-last-child"
+	}
+	return fmt.Sprintf(":%s(%s)", name, formatNth(n.a, n.b))
+}
+
+func (n nthChildNode) specificity() [3]int { return [3]int{0, 1, 0} }
+
+// formatNth renders the an+b coefficients parsed from :nth-child() and
+// friends back to their canonical CSS form.
+func formatNth(a, b int) string {
+	switch {
+	case a == 0:
+		return fmt.Sprintf("%d", b)
+	case b == 0:
+		return fmt.Sprintf("%dn", a)
+	case b > 0:
+		return fmt.Sprintf("%dn+%d", a, b)
+	default:
+		return fmt.Sprintf("%dn%d", a, b)
+	}
+}
+
+// rootSelector returns a Selector that implements :root, matching the
+// document's root element.
+func rootSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return n.Type == html.ElementNode && (n.Parent == nil || n.Parent.Type == html.DocumentNode)
+		},
+		ast: pseudoNode{"root"},
+	}
+}
+
+// emptySelector returns a Selector that implements :empty, matching
+// elements with no child nodes at all.
+func emptySelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return n.Type == html.ElementNode && n.FirstChild == nil
+		},
+		ast: pseudoNode{"empty"},
+	}
+}
+
+// firstChildSelector returns a Selector that implements :first-child.
+func firstChildSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+				if s.Type == html.ElementNode {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"first-child"},
+	}
+}
+
+// lastChildSelector returns a Selector that implements :last-child.
+func lastChildSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.NextSibling; s != nil; s = s.NextSibling {
+				if s.Type == html.ElementNode {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"last-child"},
+	}
+}
+
+// onlyChildSelector returns a Selector that implements :only-child.
+func onlyChildSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+				if s.Type == html.ElementNode {
+					return false
+				}
+			}
+			for s := n.NextSibling; s != nil; s = s.NextSibling {
+				if s.Type == html.ElementNode {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"only-child"},
+	}
+}
+
+// firstOfTypeSelector returns a Selector that implements :first-of-type.
+func firstOfTypeSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+				if s.Type == html.ElementNode && s.Data == n.Data {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"first-of-type"},
+	}
+}
+
+// lastOfTypeSelector returns a Selector that implements :last-of-type.
+func lastOfTypeSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.NextSibling; s != nil; s = s.NextSibling {
+				if s.Type == html.ElementNode && s.Data == n.Data {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"last-of-type"},
+	}
+}
+
+// onlyOfTypeSelector returns a Selector that implements :only-of-type.
+func onlyOfTypeSelector() Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+				if s.Type == html.ElementNode && s.Data == n.Data {
+					return false
+				}
+			}
+			for s := n.NextSibling; s != nil; s = s.NextSibling {
+				if s.Type == html.ElementNode && s.Data == n.Data {
+					return false
+				}
+			}
+			return true
+		},
+		ast: pseudoNode{"only-of-type"},
+	}
+}
+
+// nthOfTypeSelector returns a selector that implements :nth-of-type(an+b).
+// If last is true, implements :nth-last-of-type instead.
+func nthOfTypeSelector(a, b int, last bool) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			if n.Type != html.ElementNode {
+				return false
+			}
+			parent := n.Parent
+			if parent == nil {
+				return false
+			}
+
+			i := -1
+			count := 0
+			for c := parent.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type != html.ElementNode || c.Data != n.Data {
+					continue
+				}
+				count++
+				if c == n {
+					i = count - 1
+				}
+			}
+
+			if i == -1 {
+				return false
+			}
+
+			if last {
+				i = count - i
+			} else {
+				i++
+			}
+
+			i -= b
+			if a == 0 {
+				return i == 0
+			}
+
+			return i%a == 0 && i/a >= 0
+		},
+		ast: nthOfTypeNode{a: a, b: b, last: last},
+	}
+}
+
+// nthOfTypeNode is the AST node for ":nth-of-type(an+b)" and
+// ":nth-last-of-type(an+b)".
+type nthOfTypeNode struct {
+	a, b int
+	last bool
+}
+
+func (n nthOfTypeNode) String() string {
+	name := "nth-of-type"
+	if n.last {
+		name = "nth-last-of-type"
+	}
+	return fmt.Sprintf(":%s(%s)", name, formatNth(n.a, n.b))
+}
+
+func (n nthOfTypeNode) specificity() [3]int { return [3]int{0, 1, 0} }
 
-		return i%a == 0 && i/a >= 0
+// containsSelector returns a Selector that implements the jQuery-style
+// :contains("text") extension, matching nodes whose text (as returned by
+// nodeText) contains value as a substring. Node type is not checked, so
+// document and text nodes can match as well as elements.
+func containsSelector(value string) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			return strings.Contains(nodeText(n), value)
+		},
+		ast: containsNode{value},
 	}
 }
+
+// nodeText returns the concatenated text of n's text-node descendants, or
+// n's own data if n is itself a text node.
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+// containsNode is the AST node for ":contains(\"text\")".
+type containsNode struct{ value string }
+
+func (n containsNode) String() string { return fmt.Sprintf(":contains(%q)", n.value) }
+
+func (n containsNode) specificity() [3]int { return [3]int{0, 1, 0} }
+
+// hasSelector returns a Selector that implements the jQuery-style
+// :has(selector) extension, matching nodes (the document node included)
+// with a descendant matching inner.
+func hasSelector(inner Selector) Selector {
+	return Selector{
+		matches: func(n *html.Node) bool {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if inner.MatchFirst(c) != nil {
+					return true
+				}
+			}
+			return false
+		},
+		ast: hasNode{inner.ast},
+	}
+}
+
+// hasNode is the AST node for ":has(selector)".
+type hasNode struct{ sel selNode }
+
+func (n hasNode) String() string { return ":has(" + n.sel.String() + ")" }
+
+// specificity counts the specificity of the argument selector, per the
+// same convention as :not().
+func (n hasNode) specificity() [3]int { return n.sel.specificity() }
+
+// pseudoNode is the AST node for the simple, argument-less pseudo-classes:
+// :root, :empty, :first-child, :last-child, :only-child, :first-of-type,
+// :last-of-type, and :only-of-type.
+type pseudoNode struct{ name string }
+
+func (n pseudoNode) String() string { return ":" + n.name }
+
+func (n pseudoNode) specificity() [3]int { return [3]int{0, 1, 0} }
@@ -1,9 +1,10 @@
 package cascadia
 
 import (
-	"html"
 	"strings"
 	"testing"
+
+	"golang.org/x/net/html"
 )
 
 type selectorTest struct {
@@ -224,6 +225,92 @@ var selectorTests = []selectorTest{
 			`<li id="4">`,
 		},
 	},
+	{
+		`<html><head></head><body><p></p></body></html>`,
+		`:root`,
+		[]string{
+			`<html>`,
+		},
+	},
+	{
+		`<div><p></p><p>text</p></div>`,
+		`p:empty`,
+		[]string{
+			`<p>`,
+		},
+	},
+	{
+		`<ol><li id=1><li id=2><li id=3></ol>`,
+		`li:first-child`,
+		[]string{
+			`<li id="1">`,
+		},
+	},
+	{
+		`<ol><li id=1><li id=2><li id=3></ol>`,
+		`li:last-child`,
+		[]string{
+			`<li id="3">`,
+		},
+	},
+	{
+		`<ol><li id=1></ol><ol><li id=2></ol>`,
+		`li:only-child`,
+		[]string{
+			`<li id="1">`,
+			`<li id="2">`,
+		},
+	},
+	{
+		`<div><p id=1></p><span></span><p id=2></p></div>`,
+		`p:first-of-type`,
+		[]string{
+			`<p id="1">`,
+		},
+	},
+	{
+		`<div><p id=1></p><span></span><p id=2></p></div>`,
+		`p:last-of-type`,
+		[]string{
+			`<p id="2">`,
+		},
+	},
+	{
+		`<div><p id=1></p><p id=2></p></div><div><p id=3></p></div>`,
+		`p:only-of-type`,
+		[]string{
+			`<p id="3">`,
+		},
+	},
+	{
+		`<div><p id=1></p><span></span><p id=2></p><p id=3></p></div>`,
+		`p:nth-of-type(2n+1)`,
+		[]string{
+			`<p id="1">`,
+			`<p id="3">`,
+		},
+	},
+	{
+		`<div><p id=1></p><span></span><p id=2></p><p id=3></p></div>`,
+		`p:nth-last-of-type(1)`,
+		[]string{
+			`<p id="3">`,
+		},
+	},
+	{
+		`<ul><li>apple</li><li>banana</li></ul>`,
+		`li:contains("banana")`,
+		[]string{
+			`<li>`,
+		},
+	},
+	{
+		`<div><p><span></span></p><p></p></div>`,
+		`p:has(span)`,
+		[]string{
+			`<p>`,
+		},
+	},
 }
 
 func TestSelectors(t *testing.T) {
@@ -254,3 +341,129 @@ func TestSelectors(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchFirst(t *testing.T) {
+	for _, test := range selectorTests {
+		s, err := Compile(test.selector)
+		if err != nil {
+			t.Errorf("error compiling %q: %s", test.selector, err)
+			continue
+		}
+
+		doc, err := html.Parse(strings.NewReader(test.HTML))
+		if err != nil {
+			t.Errorf("error parsing %q: %s", test.HTML, err)
+			continue
+		}
+
+		first := s.MatchFirst(doc)
+		if len(test.results) == 0 {
+			if first != nil {
+				t.Errorf("wanted no match for %q, got %s instead", test.selector, nodeString(first))
+			}
+			continue
+		}
+
+		if first == nil {
+			t.Errorf("wanted %s, got no match instead", test.results[0])
+			continue
+		}
+
+		if got := nodeString(first); got != test.results[0] {
+			t.Errorf("wanted %s, got %s instead", test.results[0], got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<ul><li id="a" class="x"><li id="b"><li id="c" class="x"></ul>`))
+	if err != nil {
+		t.Fatalf("error parsing HTML: %s", err)
+	}
+
+	all, err := Compile("li")
+	if err != nil {
+		t.Fatalf("error compiling selector: %s", err)
+	}
+	nodes := all.MatchAll(doc)
+
+	s, err := Compile(".x")
+	if err != nil {
+		t.Fatalf("error compiling selector: %s", err)
+	}
+
+	var m Matcher = s
+	filtered := m.Filter(nodes)
+	if len(filtered) != 2 {
+		t.Fatalf("wanted 2 elements, got %d instead", len(filtered))
+	}
+	if got := nodeString(filtered[0]); got != `<li id="a" class="x">` {
+		t.Errorf("wanted <li id=\"a\" class=\"x\">, got %s instead", got)
+	}
+	if got := nodeString(filtered[1]); got != `<li id="c" class="x">` {
+		t.Errorf("wanted <li id=\"c\" class=\"x\">, got %s instead", got)
+	}
+}
+
+var serializationTests = []struct {
+	selector, canonical string
+}{
+	{"address", "address"},
+	{"*", "*"},
+	{"#foo", "#foo"},
+	{".t1", ".t1"},
+	{"p.t1", "p.t1"},
+	{"p.t1.t2", "p.t1.t2"},
+	{"p[title]", `p[title]`},
+	{`address[title="foo"]`, `address[title="foo"]`},
+	{".t1:not(.t2)", ".t1:not(.t2)"},
+	{"li:nth-child(odd)", "li:nth-child(2n+1)"},
+	{"li:nth-child(3n+1)", "li:nth-child(3n+1)"},
+	{"div.foo > a[href^=\"http\"] + span", `div.foo > a[href^="http"] + span`},
+	{"a, b, c", "a, b, c"},
+}
+
+func TestString(t *testing.T) {
+	for _, test := range serializationTests {
+		s, err := Compile(test.selector)
+		if err != nil {
+			t.Errorf("error compiling %q: %s", test.selector, err)
+			continue
+		}
+
+		if got := s.String(); got != test.canonical {
+			t.Errorf("compiling %q: wanted %q, got %q instead", test.selector, test.canonical, got)
+		}
+	}
+}
+
+var specificityTests = []struct {
+	selector    string
+	specificity [3]int
+}{
+	{"*", [3]int{0, 0, 0}},
+	{"li", [3]int{0, 0, 1}},
+	{".t1", [3]int{0, 1, 0}},
+	{"li.t1", [3]int{0, 1, 1}},
+	{"#foo", [3]int{1, 0, 0}},
+	{"li#foo", [3]int{1, 0, 1}},
+	{`li[title="foo"]`, [3]int{0, 1, 1}},
+	{".t1:not(.t2)", [3]int{0, 2, 0}},
+	{"li:nth-child(2n+1)", [3]int{0, 1, 1}},
+	{"ul li.t1", [3]int{0, 1, 2}},
+	{"ul > li.t1", [3]int{0, 1, 2}},
+}
+
+func TestSpecificity(t *testing.T) {
+	for _, test := range specificityTests {
+		s, err := Compile(test.selector)
+		if err != nil {
+			t.Errorf("error compiling %q: %s", test.selector, err)
+			continue
+		}
+
+		if got := s.Specificity(); got != test.specificity {
+			t.Errorf("compiling %q: wanted %v, got %v instead", test.selector, test.specificity, got)
+		}
+	}
+}